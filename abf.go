@@ -23,20 +23,22 @@ package abf
 import (
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 //api urls
-const (
-	abfPickupURL = "https://www.abfs.com/xml/pickupxml.asp"
-)
+//abfPickupURL is a var, not a const, so tests can point it at an httptest.Server
+var abfPickupURL = "https://www.abfs.com/xml/pickupxml.asp"
 
 //testMode is set to "Y" by default and can be overridden by calling SetProductionMode()
 //Setting this to "Y" will not schedule an actual pickup.
@@ -144,13 +146,52 @@ type Commodity struct {
 //Response is the data returned from ABF
 //this is an xml
 type Response struct {
-	XMLName            xml.Name    `xml:"ABF"`
-	ConfirmationNumber string      `xml:"CONFIRMATION"` //only returned when a pickup is successfully scheduled
-	Ship               interface{} `xml:"SHIP"`         //shipper information
-	Consignee          interface{} `xml:"CONS"`         //consignee info
-	ThirdParty         interface{} `xml:"TPB"`          //third party info
-	NumErrors          uint        `xml:"NUMERRORS"`    //0 if a confirmation number is returned
-	Error              Error       `xml:"ERROR"`        //any error messages
+	XMLName            xml.Name       `xml:"ABF"`
+	ConfirmationNumber string         `xml:"CONFIRMATION"` //only returned when a pickup is successfully scheduled
+	Ship               ShipInfo       `xml:"SHIP"`         //shipper information
+	Consignee          ConsigneeInfo  `xml:"CONS"`         //consignee info
+	ThirdParty         ThirdPartyInfo `xml:"TPB"`          //third party info
+	NumErrors          uint           `xml:"NUMERRORS"`    //0 if a confirmation number is returned
+	Errors             []Error        `xml:"ERROR"`        //every validation error ABF returned, not just the first
+}
+
+//ShipInfo is the shipper information ABF echoes back on a Response, normalized
+//(ex: validated zip) from what was sent on the PickupRequest
+type ShipInfo struct {
+	Contact string `xml:"CONTACT"`
+	Name    string `xml:"NAME"`
+	Address string `xml:"ADDRESS"`
+	City    string `xml:"CITY"`
+	State   string `xml:"STATE"`
+	Zip     string `xml:"ZIP"` //normalized/validated zip
+	Country string `xml:"COUNTRY"`
+	Phone   string `xml:"PHONE"`
+}
+
+//ConsigneeInfo is the consignee (receiver) information ABF echoes back on a
+//Response, normalized (ex: validated zip) from what was sent on the PickupRequest
+type ConsigneeInfo struct {
+	Contact string `xml:"CONTACT"`
+	Name    string `xml:"NAME"`
+	Address string `xml:"ADDRESS"`
+	City    string `xml:"CITY"`
+	State   string `xml:"STATE"`
+	Zip     string `xml:"ZIP"` //normalized/validated zip
+	Country string `xml:"COUNTRY"`
+	Phone   string `xml:"PHONE"`
+}
+
+//ThirdPartyInfo is the third party billing information ABF echoes back on a
+//Response, normalized (ex: validated zip) from what was sent on the PickupRequest
+type ThirdPartyInfo struct {
+	Contact string `xml:"CONTACT"`
+	Name    string `xml:"NAME"`
+	Address string `xml:"ADDRESS"`
+	City    string `xml:"CITY"`
+	State   string `xml:"STATE"`
+	Zip     string `xml:"ZIP"` //normalized/validated zip
+	Country string `xml:"COUNTRY"`
+	Phone   string `xml:"PHONE"`
 }
 
 //Error is any error from the request
@@ -159,7 +200,159 @@ type Error struct {
 	Message string `xml:"ERRORMESSAGE"`
 }
 
+//PickupError wraps every validation error ABF returned for a pickup request so
+//callers get the full list in one round-trip instead of just the first message.
+//It implements error, so it can still be returned and printed like any other
+//error, but callers that want the individual codes/messages can use errors.As
+//to pull out the underlying *PickupError.
+type PickupError struct {
+	Errors []Error
+}
+
+//Error implements the error interface
+func (e *PickupError) Error() string {
+	if len(e.Errors) == 0 {
+		return "abf: pickup request failed"
+	}
+
+	msgs := make([]string, len(e.Errors))
+	for i, er := range e.Errors {
+		msgs[i] = er.Code + ": " + er.Message
+	}
+	return "abf: pickup request failed: " + strings.Join(msgs, "; ")
+}
+
+//ServerError means ABF responded with an HTTP 5xx status instead of the usual XML
+//body, which usually means ABF is briefly overloaded or restarting. A Client's
+//RetryPolicy retries 5xx responses before this is returned; DeliveryQueue does
+//not retry it again.
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("abf: server error, status %d", e.StatusCode)
+}
+
+//Client holds ABF API credentials and connection settings so they can be reused
+//across many requests instead of relying on the package-level testMode and timeout
+//globals. Create one with NewClient() and reuse it; a Client is safe for concurrent
+//use by multiple goroutines, which also means multiple Clients with different
+//credentials can run side by side in the same process.
+type Client struct {
+	APIKey     string //api key, ABF's "ID" field
+	TestMode   string //"Y" or "N"; see SetProductionMode
+	Timeout    time.Duration
+	HTTPClient *http.Client
+
+	//Policies wrap HTTPClient in order, outermost first, letting callers install
+	//cross-cutting behavior (logging, request ID injection, retry, metrics, ...)
+	//without RequestPickup/RateQuote knowing about any of it. NewClient installs
+	//a sane default stack; set Policies directly to customize or clear it.
+	Policies []Policy
+
+	//hasRetryPolicy tracks whether a RetryPolicy has been installed on Policies,
+	//so NewDeliveryQueue can tell a Client built by NewClient apart from a bare
+	//&Client{} literal and install one itself rather than silently dropping 5xx
+	//retry. Set by NewClient and UseRetryPolicy; not part of the exported API
+	//because callers who edit Policies directly are expected to know what
+	//they're doing.
+	hasRetryPolicy bool
+
+	//token and tokenExpiry cache a bearer-style auth token, mirroring how the
+	//xpologistics package reuses a 12 hour token across calls. ABF's pickup and
+	//rate quote endpoints don't require a token today, so nothing populates this
+	//yet, but cachedToken() is here so an auth'd endpoint can be wired up without
+	//another refactor of Client.
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+//NewClient creates a Client for making ABF API calls with the given api key.
+//Defaults to test mode and installs the default policy stack (error-level
+//logging and 5xx retry); call SetProductionMode and SetTimeout to override.
+func NewClient(apiKey string) *Client {
+	c := &Client{
+		APIKey:   apiKey,
+		TestMode: "Y",
+		Timeout:  timeout,
+	}
+	c.HTTPClient = &http.Client{
+		Timeout: c.Timeout,
+	}
+	c.Policies = []Policy{
+		LoggingPolicy(LogLevelError),
+		RetryPolicy(DefaultBackoff),
+	}
+	c.hasRetryPolicy = true
+	return c
+}
+
+//UseRetryPolicy appends a RetryPolicy using backoff to the Client's Policies.
+//Call this on a Client built as a bare &Client{} literal (rather than with
+//NewClient) so it still retries 5xx responses; NewDeliveryQueue calls this
+//automatically on a Client that doesn't already have a retry policy, but
+//callers using the Client directly, without a DeliveryQueue, need to call it
+//themselves.
+func (c *Client) UseRetryPolicy(backoff BackoffConfig) {
+	c.Policies = append(c.Policies, RetryPolicy(backoff))
+	c.hasRetryPolicy = true
+}
+
+//do sends req through the Client's Policies wrapped around HTTPClient, so every
+//outgoing request gets the same logging/retry/metrics/etc. instead of
+//RequestPickup and RateQuote each building an http.Client inline.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: c.Timeout}
+	}
+
+	base := transportFunc(httpClient.Do)
+	return buildTransport(base, c.Policies).Do(req)
+}
+
+//SetProductionMode turns test mode off (TestMode "N") so pickups are actually
+//scheduled; the same url is used either way
+func (c *Client) SetProductionMode(yes bool) {
+	if yes {
+		c.TestMode = "N"
+	}
+	return
+}
+
+//SetTimeout updates the timeout value to something the user sets
+//use this to increase the timeout if connecting to ABF is really slow
+func (c *Client) SetTimeout(seconds time.Duration) {
+	c.Timeout = time.Duration(seconds * time.Second)
+	c.HTTPClient.Timeout = c.Timeout
+	return
+}
+
+//cachedToken returns the cached auth token if it is still valid, otherwise it calls
+//fetch to obtain a new one and caches it. Locking makes this safe to call from many
+//goroutines sharing a Client so a token refresh doesn't stampede the auth endpoint.
+func (c *Client) cachedToken(fetch func() (token string, ttl time.Duration, err error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	token, ttl, err := fetch()
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token
+	c.tokenExpiry = time.Now().Add(ttl)
+	return c.token, nil
+}
+
 //SetProductionMode chooses the production url for use
+//this sets the package-wide default used by the back-compat PickupRequest.RequestPickup()
 func SetProductionMode(yes bool) {
 	if yes {
 		testMode = "N"
@@ -168,7 +361,8 @@ func SetProductionMode(yes bool) {
 }
 
 //SetTimeout updates the timeout value to something the user sets
-//use this to increase the timeout if connecting to UPS is really slow
+//use this to increase the timeout if connecting to ABF is really slow
+//this sets the package-wide default used by the back-compat PickupRequest.RequestPickup()
 func SetTimeout(seconds time.Duration) {
 	timeout = time.Duration(seconds * time.Second)
 	return
@@ -176,10 +370,17 @@ func SetTimeout(seconds time.Duration) {
 
 //RequestPickup makes the api call to schedule the pickup
 //this is a url with url parameters
-func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
-	//set timeout
-	httpClient := http.Client{
-		Timeout: timeout,
+func (c *Client) RequestPickup(p *PickupRequest) (responseData Response, err error) {
+	//the Client's api key stands in for the request's ID when the request leaves it empty
+	if p.ID == "" && c.APIKey != "" {
+		withKey := *p
+		withKey.ID = c.APIKey
+		p = &withKey
+	}
+
+	//fail fast locally instead of round-tripping to ABF for problems we can catch here
+	if err = p.Validate(); err != nil {
+		return
 	}
 
 	//build the request parameters
@@ -208,21 +409,42 @@ func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
 	v.Add("CRN1", p.CRN1)
 
 	//set test mode
-	v.Add("Test", testMode)
-
-	log.Println(v.Encode())
+	v.Add("Test", c.TestMode)
 
 	for index, item := range p.Items {
-		v.Add("HN"+strconv.Itoa(index), strconv.Itoa(int(item.HandlingUnits)))
-		v.Add("HT"+strconv.Itoa(index), item.UnitType)
-		v.Add("PN"+strconv.Itoa(index), strconv.Itoa(int(item.Pieces)))
-		v.Add("PT"+strconv.Itoa(index), item.PiecesType)
-		v.Add("WT"+strconv.Itoa(index), strconv.FormatFloat(item.Weight, 'f', 0, 64))
+		//ABF's per-line parameters are numbered HN1-HN15, not zero-based
+		i := strconv.Itoa(index + 1)
+		v.Add("HN"+i, strconv.Itoa(int(item.HandlingUnits)))
+		v.Add("HT"+i, item.UnitType)
+		v.Add("PN"+i, strconv.Itoa(int(item.Pieces)))
+		v.Add("PT"+i, item.PiecesType)
+		v.Add("WT"+i, strconv.FormatFloat(item.Weight, 'f', 0, 64))
+		v.Add("CL"+i, item.Class)
+		v.Add("NMFC"+i, item.NMFC)
+		v.Add("SUB"+i, item.NMFCSub)
+		v.Add("CB"+i, strconv.FormatFloat(item.Cube, 'f', 0, 64))
+		v.Add("Desc"+i, item.Description)
+		v.Add("Hazmat"+i, item.Hazmat)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, abfPickupURL, bytes.NewBufferString(v.Encode()))
+	if err != nil {
+		err = errors.Wrap(err, "abf.RequestPickup - could not build request")
+		return
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := httpClient.Post(abfPickupURL, "application/x-www-form-urlencoded", bytes.NewBufferString(v.Encode()))
+	res, err := c.do(req)
 	if err != nil {
-		errors.Wrap(err, "abf.RequestPickup - could not make post request")
+		err = errors.Wrap(err, "abf.RequestPickup - could not make post request")
+		return
+	}
+
+	//treat a 5xx as its own error type so a DeliveryQueue (or other caller) can
+	//tell "ABF is briefly unhappy, retry me" apart from a validation failure
+	if res.StatusCode >= http.StatusInternalServerError {
+		res.Body.Close()
+		err = &ServerError{StatusCode: res.StatusCode}
 		return
 	}
 
@@ -230,13 +452,13 @@ func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		errors.Wrap(err, "abf.RequestPickup - could not read response")
+		err = errors.Wrap(err, "abf.RequestPickup - could not read response")
 		return
 	}
 
 	err = xml.Unmarshal(body, &responseData)
 	if err != nil {
-		errors.Wrap(err, "abf.RequestPickup - could not unmarshal response")
+		err = errors.Wrap(err, "abf.RequestPickup - could not unmarshal response")
 		return
 	}
 
@@ -246,9 +468,8 @@ func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
 		log.Println("abf.RequestPickup - pickup request failed")
 		log.Printf("%+v", responseData)
 
-		//return our error so we know where this error came from, and UPS error message so we know what to fix
-		err = errors.New("abf.RequestPickup - pickup request failed")
-		err = errors.Wrap(err, responseData.Error.Message)
+		//return every validation error ABF sent back, not just the first one
+		err = &PickupError{Errors: responseData.Errors}
 		return
 	}
 
@@ -256,3 +477,15 @@ func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
 	//response data will have confirmation number
 	return
 }
+
+//RequestPickup makes the api call to schedule the pickup
+//this is a url with url parameters
+//kept for back-compat; it builds a Client from the package-level testMode and
+//timeout globals and delegates to Client.RequestPickup(). New code should create
+//a Client with NewClient() and call its RequestPickup() method directly.
+func (p *PickupRequest) RequestPickup() (responseData Response, err error) {
+	c := NewClient(p.ID)
+	c.TestMode = testMode
+	c.SetTimeout(timeout / time.Second)
+	return c.RequestPickup(p)
+}
@@ -0,0 +1,200 @@
+package abf
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+//validPickupRequest builds a PickupRequest with n commodities that passes
+//Validate(), for tests that only care about what gets sent over the wire.
+func validPickupRequest(n int) *PickupRequest {
+	items := make([]Commodity, n)
+	for i := range items {
+		items[i] = Commodity{
+			HandlingUnits: uint(i + 1),
+			UnitType:      HandlingUnitPallet,
+			Pieces:        uint(i + 1),
+			PiecesType:    "BOX",
+			Weight:        100 + float64(i),
+			Class:         "70",
+			NMFC:          "12345",
+			NMFCSub:       "01",
+			Cube:          10 + float64(i),
+			Description:   "widgets",
+			Hazmat:        "N",
+		}
+	}
+
+	return &PickupRequest{
+		ID:            "test-key",
+		RequesterType: RequesterShipper,
+		PayTerms:      PayTermsPrepaid,
+		ShipContact:   "Jane Doe",
+		ShipName:      "Acme Co",
+		ShipAddress:   "123 Main St",
+		ShipCity:      "Springfield",
+		ShipState:     "IL",
+		ShipZip:       "62704",
+		ShipCountry:   "USA",
+		ShipPhone:     "5551234567",
+		ConsCity:      "Chicago",
+		ConsState:     "IL",
+		ConsZip:       "60601",
+		ConsCountry:   "USA",
+		PickupDate:    "01/02/2030",
+		AT:            "08:00",
+		OT:            "08:00",
+		CT:            "17:00",
+		Items:         items,
+	}
+}
+
+//TestRequestPickup_CommodityFormValues guards against the HN0/HT0/... off-by-one
+//regression and asserts every per-line field Commodity defines actually makes it
+//onto the wire.
+func TestRequestPickup_CommodityFormValues(t *testing.T) {
+	for _, n := range []int{1, 5, 15} {
+		n := n
+		t.Run(strconv.Itoa(n)+"_commodities", func(t *testing.T) {
+			var gotForm url.Values
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Fatalf("could not read request body: %v", err)
+				}
+
+				gotForm, err = url.ParseQuery(string(body))
+				if err != nil {
+					t.Fatalf("could not parse form body: %v", err)
+				}
+
+				w.Write([]byte(`<ABF><CONFIRMATION>123</CONFIRMATION></ABF>`))
+			}))
+			defer server.Close()
+
+			originalURL := abfPickupURL
+			abfPickupURL = server.URL
+			defer func() { abfPickupURL = originalURL }()
+
+			p := validPickupRequest(n)
+			c := NewClient(p.ID)
+			c.Policies = nil //no retry/logging noise in this test
+
+			if _, err := c.RequestPickup(p); err != nil {
+				t.Fatalf("RequestPickup returned error: %v", err)
+			}
+
+			for i, item := range p.Items {
+				idx := strconv.Itoa(i + 1)
+				assertFormValue(t, gotForm, "HN"+idx, strconv.Itoa(int(item.HandlingUnits)))
+				assertFormValue(t, gotForm, "HT"+idx, item.UnitType)
+				assertFormValue(t, gotForm, "PN"+idx, strconv.Itoa(int(item.Pieces)))
+				assertFormValue(t, gotForm, "PT"+idx, item.PiecesType)
+				assertFormValue(t, gotForm, "WT"+idx, strconv.FormatFloat(item.Weight, 'f', 0, 64))
+				assertFormValue(t, gotForm, "CL"+idx, item.Class)
+				assertFormValue(t, gotForm, "NMFC"+idx, item.NMFC)
+				assertFormValue(t, gotForm, "SUB"+idx, item.NMFCSub)
+				assertFormValue(t, gotForm, "CB"+idx, strconv.FormatFloat(item.Cube, 'f', 0, 64))
+				assertFormValue(t, gotForm, "Desc"+idx, item.Description)
+				assertFormValue(t, gotForm, "Hazmat"+idx, item.Hazmat)
+			}
+
+			//guard against the zero-indexed regression specifically
+			if gotForm.Get("HN0") != "" || gotForm.Get("HT0") != "" {
+				t.Fatalf("found zero-indexed per-item field, off-by-one regression: %v", gotForm)
+			}
+		})
+	}
+}
+
+func assertFormValue(t *testing.T, form url.Values, key, want string) {
+	t.Helper()
+	if got := form.Get(key); got != want {
+		t.Errorf("form value %s = %q, want %q", key, got, want)
+	}
+}
+
+//canned ABF XML responses used by TestRequestPickup_ResponseFixtures
+const (
+	successXML = `<ABF>
+	<CONFIRMATION>ABC123</CONFIRMATION>
+	<SHIP><CONTACT>Jane Doe</CONTACT><ZIP>62704</ZIP></SHIP>
+	<NUMERRORS>0</NUMERRORS>
+</ABF>`
+
+	singleErrorXML = `<ABF>
+	<NUMERRORS>1</NUMERRORS>
+	<ERROR><ERRORCODE>E1</ERRORCODE><ERRORMESSAGE>Missing ShipContact</ERRORMESSAGE></ERROR>
+</ABF>`
+
+	multiErrorXML = `<ABF>
+	<NUMERRORS>2</NUMERRORS>
+	<ERROR><ERRORCODE>E1</ERRORCODE><ERRORMESSAGE>Missing ShipContact</ERRORMESSAGE></ERROR>
+	<ERROR><ERRORCODE>E2</ERRORCODE><ERRORMESSAGE>Invalid ConsZip</ERRORMESSAGE></ERROR>
+</ABF>`
+)
+
+//TestRequestPickup_ResponseFixtures asserts that a Response with no errors
+//decodes into a confirmation number, and that a Response with one or more
+//ERROR elements decodes every one of them into a *PickupError, not just the
+//first.
+func TestRequestPickup_ResponseFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		xml      string
+		wantConf string
+		wantErrs int
+	}{
+		{name: "success", xml: successXML, wantConf: "ABC123"},
+		{name: "single error", xml: singleErrorXML, wantErrs: 1},
+		{name: "multi error", xml: multiErrorXML, wantErrs: 2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.xml))
+			}))
+			defer server.Close()
+
+			originalURL := abfPickupURL
+			abfPickupURL = server.URL
+			defer func() { abfPickupURL = originalURL }()
+
+			p := validPickupRequest(1)
+			c := NewClient(p.ID)
+			c.Policies = nil //no retry/logging noise in this test
+
+			res, err := c.RequestPickup(p)
+
+			if tt.wantConf != "" {
+				if err != nil {
+					t.Fatalf("RequestPickup returned unexpected error: %v", err)
+				}
+				if res.ConfirmationNumber != tt.wantConf {
+					t.Errorf("ConfirmationNumber = %q, want %q", res.ConfirmationNumber, tt.wantConf)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("RequestPickup returned no error, want a *PickupError")
+			}
+
+			var pickupErr *PickupError
+			if !errors.As(err, &pickupErr) {
+				t.Fatalf("RequestPickup error is %T, want *PickupError", err)
+			}
+			if len(pickupErr.Errors) != tt.wantErrs {
+				t.Errorf("got %d errors, want %d: %+v", len(pickupErr.Errors), tt.wantErrs, pickupErr.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package abf
+
+import (
+	"context"
+	"crypto/x509"
+	stderrors "errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//ErrCancelled is returned on a job's result channel when it is cancelled with
+//DeliveryQueue.Cancel before a worker picks it up.
+var ErrCancelled = stderrors.New("abf: pickup request cancelled before dispatch")
+
+//ErrQueueStopped is returned on a job's result channel when DeliveryQueue.Stop
+//is called before a worker ever picked the job up.
+var ErrQueueStopped = stderrors.New("abf: pickup request still queued when DeliveryQueue was stopped")
+
+//BackoffConfig controls the exponential backoff a DeliveryQueue worker uses
+//between retries of a single pickup request.
+type BackoffConfig struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxRetries      int
+}
+
+//DefaultBackoff is a reasonable retry schedule for a flaky ABF endpoint.
+var DefaultBackoff = BackoffConfig{
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     30 * time.Second,
+	MaxRetries:      5,
+}
+
+//Result is what a queued pickup request resolves to once a worker has attempted it.
+type Result struct {
+	ID       string
+	Response Response
+	Err      error
+}
+
+//job is a PickupRequest waiting in a DeliveryQueue, plus everything needed to
+//track and cancel it before it's dispatched.
+type job struct {
+	id      string
+	ctx     context.Context
+	request *PickupRequest
+	result  chan Result
+}
+
+//DeliveryQueue is a bounded, worker-backed queue for submitting PickupRequests to
+//ABF asynchronously. Use this instead of calling RequestPickup directly when ABF
+//being slow or briefly unavailable shouldn't block or lose the caller's request.
+type DeliveryQueue struct {
+	client  *Client
+	workers int
+	backoff BackoffConfig
+
+	jobs chan *job
+
+	mu      sync.Mutex
+	pending map[string]*job //queued, not yet dispatched; removable by Cancel
+	parked  map[string]*job //dispatch hit a bad-host error and won't be retried automatically
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	nextID uint64
+}
+
+//NewDeliveryQueue creates a DeliveryQueue that dispatches pickup requests through
+//client using workers concurrent goroutines. queueSize bounds how many requests
+//can be waiting for a free worker before Enqueue blocks.
+//
+//The queue itself only retries what isRetryable considers transient (network
+//timeouts); 5xx responses are retried by client's RetryPolicy instead, so the
+//two layers don't multiply retries on top of each other. If client doesn't
+//already have a RetryPolicy installed, e.g. it was built as a bare &Client{}
+//literal instead of with NewClient, NewDeliveryQueue installs one with backoff
+//so 5xx retry isn't silently lost.
+func NewDeliveryQueue(client *Client, workers, queueSize int, backoff BackoffConfig) *DeliveryQueue {
+	if client != nil && !client.hasRetryPolicy {
+		client.UseRetryPolicy(backoff)
+	}
+
+	return &DeliveryQueue{
+		client:  client,
+		workers: workers,
+		backoff: backoff,
+		jobs:    make(chan *job, queueSize),
+		pending: make(map[string]*job),
+		parked:  make(map[string]*job),
+	}
+}
+
+//Start launches the worker pool. It returns immediately; workers keep running
+//until ctx is done or Stop is called.
+func (q *DeliveryQueue) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	q.cancel = cancel
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(ctx)
+	}
+}
+
+//Stop cancels any in-flight retries and waits for all workers to exit.
+func (q *DeliveryQueue) Stop() {
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.wg.Wait()
+
+	//anything still in pending was buffered in the channel but no worker ever got
+	//to it before exiting; deliver a terminal result so callers blocked on
+	//<-result don't hang forever
+	q.mu.Lock()
+	remaining := q.pending
+	q.pending = make(map[string]*job)
+	q.mu.Unlock()
+
+	for _, j := range remaining {
+		j.result <- Result{ID: j.id, Err: ErrQueueStopped}
+		close(j.result)
+	}
+}
+
+//Enqueue submits a pickup request to the queue and returns a unique ID plus a
+//channel that receives exactly one Result once a worker has attempted it,
+//exhausted its retries, parked it as a bad host, or the caller cancelled it.
+//ctx governs the request itself, not the queue; cancelling ctx stops retries for
+//this request without affecting the queue or other requests. Enqueue blocks if
+//the queue is full.
+func (q *DeliveryQueue) Enqueue(ctx context.Context, request *PickupRequest) (string, <-chan Result) {
+	id := q.newID()
+	result := make(chan Result, 1)
+	j := &job{id: id, ctx: ctx, request: request, result: result}
+
+	q.mu.Lock()
+	q.pending[id] = j
+	q.mu.Unlock()
+
+	q.jobs <- j
+
+	return id, result
+}
+
+//Cancel removes a request from the queue before it is dispatched, delivering
+//ErrCancelled on its result channel. It returns false if the request has already
+//been picked up by a worker, was already parked, or doesn't exist.
+func (q *DeliveryQueue) Cancel(id string) bool {
+	q.mu.Lock()
+	j, ok := q.pending[id]
+	if ok {
+		delete(q.pending, id)
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	j.result <- Result{ID: id, Err: ErrCancelled}
+	close(j.result)
+	return true
+}
+
+//newID hands out a unique, monotonically increasing job ID.
+func (q *DeliveryQueue) newID() string {
+	n := atomic.AddUint64(&q.nextID, 1)
+	return fmt.Sprintf("pickup-%d", n)
+}
+
+//worker pulls jobs off the queue and dispatches them until ctx is done.
+func (q *DeliveryQueue) worker(ctx context.Context) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j, ok := <-q.jobs:
+			if !ok {
+				return
+			}
+
+			q.mu.Lock()
+			_, stillPending := q.pending[j.id]
+			if stillPending {
+				delete(q.pending, j.id)
+			}
+			q.mu.Unlock()
+
+			//Cancel already delivered the result if this job was removed from pending
+			if !stillPending {
+				continue
+			}
+
+			q.dispatch(ctx, j)
+		}
+	}
+}
+
+//dispatch attempts a pickup request with exponential backoff, parking it instead
+//of retrying if the failure looks like a bad host (DNS/TLS) rather than a
+//transient ABF error.
+func (q *DeliveryQueue) dispatch(ctx context.Context, j *job) {
+	interval := q.backoff.InitialInterval
+	var resp Response
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		resp, err = q.client.RequestPickup(j.request)
+		if err == nil {
+			j.result <- Result{ID: j.id, Response: resp}
+			close(j.result)
+			return
+		}
+
+		//check isRetryable first: a dial timeout is still a timeout even though a
+		//net.OpError wraps it, and transient errors like that are exactly what the
+		//queue exists to retry, not park
+		if isRetryable(err) && attempt < q.backoff.MaxRetries {
+			select {
+			case <-time.After(jitter(interval)):
+			case <-j.ctx.Done():
+				j.result <- Result{ID: j.id, Err: j.ctx.Err()}
+				close(j.result)
+				return
+			case <-ctx.Done():
+				j.result <- Result{ID: j.id, Err: ctx.Err()}
+				close(j.result)
+				return
+			}
+
+			interval *= 2
+			if interval > q.backoff.MaxInterval {
+				interval = q.backoff.MaxInterval
+			}
+			continue
+		}
+
+		if isBadHostError(err) {
+			q.mu.Lock()
+			q.parked[j.id] = j
+			q.mu.Unlock()
+			j.result <- Result{ID: j.id, Err: errors.Wrap(err, "abf.DeliveryQueue - parked after bad host error")}
+			close(j.result)
+			return
+		}
+
+		msg := "abf.DeliveryQueue - request failed"
+		if isRetryable(err) {
+			msg = "abf.DeliveryQueue - exhausted retries"
+		}
+		j.result <- Result{ID: j.id, Err: errors.Wrap(err, msg)}
+		close(j.result)
+		return
+	}
+}
+
+//isRetryable reports whether err looks transient enough for the queue to retry.
+//A *ServerError is deliberately excluded: the Client's own RetryPolicy already
+//backs off and retries 5xx responses before RequestPickup returns, so retrying
+//it again here would just multiply the same backoff on top of itself. This layer
+//only picks up what the transport doesn't retry, like a network timeout.
+func isRetryable(err error) bool {
+	var netErr net.Error
+	if stderrors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+//isBadHostError reports whether err is a DNS or TLS failure, meaning ABF's host
+//is unreachable or misconfigured and retrying the same host won't help. This is
+//deliberately narrow: a plain dial error (connection refused, dial timeout,
+//network unreachable) is NOT a bad host and is never parked. isRetryable retries
+//it only when it is a timeout; otherwise dispatch fails it without retrying.
+func isBadHostError(err error) bool {
+	var dnsErr *net.DNSError
+	if stderrors.As(err, &dnsErr) {
+		return true
+	}
+
+	var certInvalid x509.CertificateInvalidError
+	if stderrors.As(err, &certInvalid) {
+		return true
+	}
+
+	var hostnameErr x509.HostnameError
+	if stderrors.As(err, &hostnameErr) {
+		return true
+	}
+
+	var unknownAuth x509.UnknownAuthorityError
+	if stderrors.As(err, &unknownAuth) {
+		return true
+	}
+
+	return false
+}
+
+//jitter adds up to d of random jitter on top of d/2 so retrying workers don't
+//all hammer ABF in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,160 @@
+package abf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//api urls
+const (
+	abfRateQuoteURL = "https://www.abfs.com/xml/ratequotexml.asp"
+)
+
+//RateQuoteRequest is the data sent to ABF to get an LTL rate quote
+type RateQuoteRequest struct {
+	//required
+	ID        string //api key
+	OriginZip string
+	DestZip   string
+	PayTerms  string      //"P" = prepaid, "C" = collect
+	Items     []Commodity //list of commodities being quoted, up to 15
+
+	//optional
+	Accessorials []string //list of accessorial codes, ex: "LIFO" (lift gate origin), "IEP" (inside pickup)
+}
+
+//RateQuoteResponse is the data returned from ABF for a rate quote request
+//this is an xml
+type RateQuoteResponse struct {
+	XMLName     xml.Name        `xml:"ABF"`
+	TotalCharge float64         `xml:"TOTALCHARGE"`
+	Discount    float64         `xml:"DISCOUNT"`
+	TransitDays uint            `xml:"TRANSITDAYS"`
+	Lines       []RateQuoteLine `xml:"DETAIL"`
+	NumErrors   uint            `xml:"NUMERRORS"`
+	Errors      []Error         `xml:"ERROR"`
+}
+
+//RateQuoteLine is the charge breakdown for a single line/commodity of a rate quote
+type RateQuoteLine struct {
+	Description string  `xml:"DESC"`
+	Charge      float64 `xml:"CHARGE"`
+}
+
+//RateQuoteError wraps every validation error ABF returned for a rate quote
+//request so callers get the full list in one round-trip instead of just the
+//first message, mirroring PickupError.
+type RateQuoteError struct {
+	Errors []Error
+}
+
+//Error implements the error interface
+func (e *RateQuoteError) Error() string {
+	if len(e.Errors) == 0 {
+		return "abf: rate quote request failed"
+	}
+
+	msgs := make([]string, len(e.Errors))
+	for i, er := range e.Errors {
+		msgs[i] = er.Code + ": " + er.Message
+	}
+	return "abf: rate quote request failed: " + strings.Join(msgs, "; ")
+}
+
+//RateQuote makes the api call to get an LTL rate quote, routed through the
+//Client's Policies like RequestPickup so rate quotes get the same
+//logging/retry/timeout behavior instead of building their own http.Client.
+func (c *Client) RateQuote(r *RateQuoteRequest) (responseData RateQuoteResponse, err error) {
+	//build the request parameters
+	v := url.Values{}
+	//the Client's api key stands in for the request's ID when the request leaves it empty
+	id := r.ID
+	if id == "" {
+		id = c.APIKey
+	}
+	v.Add("ID", id)
+	v.Add("OriginZip", r.OriginZip)
+	v.Add("DestZip", r.DestZip)
+	v.Add("PayTerms", r.PayTerms)
+
+	for _, code := range r.Accessorials {
+		v.Add("Accessorial", code)
+	}
+
+	for index, item := range r.Items {
+		//ABF's per-line parameters are numbered HN1-HN15, not zero-based
+		i := strconv.Itoa(index + 1)
+		v.Add("HN"+i, strconv.Itoa(int(item.HandlingUnits)))
+		v.Add("HT"+i, item.UnitType)
+		v.Add("WT"+i, strconv.FormatFloat(item.Weight, 'f', 0, 64))
+		v.Add("CL"+i, item.Class)
+		v.Add("NMFC"+i, item.NMFC)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, abfRateQuoteURL, bytes.NewBufferString(v.Encode()))
+	if err != nil {
+		err = errors.Wrap(err, "abf.RateQuote - could not build request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.do(req)
+	if err != nil {
+		err = errors.Wrap(err, "abf.RateQuote - could not make post request")
+		return
+	}
+
+	//treat a 5xx as its own error type so a caller can tell "ABF is briefly
+	//unhappy, retry me" apart from a validation failure, same as RequestPickup
+	if res.StatusCode >= http.StatusInternalServerError {
+		res.Body.Close()
+		err = &ServerError{StatusCode: res.StatusCode}
+		return
+	}
+
+	//read the response
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		err = errors.Wrap(err, "abf.RateQuote - could not read response")
+		return
+	}
+
+	err = xml.Unmarshal(body, &responseData)
+	if err != nil {
+		err = errors.Wrap(err, "abf.RateQuote - could not unmarshal response")
+		return
+	}
+
+	if responseData.NumErrors > 0 {
+		log.Println("abf.RateQuote - rate quote request failed")
+		log.Printf("%+v", responseData)
+
+		//return every validation error ABF sent back, not just the first one
+		err = &RateQuoteError{Errors: responseData.Errors}
+		return
+	}
+
+	//rate quote successful
+	return
+}
+
+//RateQuote makes the api call to get an LTL rate quote
+//kept for back-compat; it builds a Client from the package-level testMode and
+//timeout globals and delegates to Client.RateQuote(). New code should create a
+//Client with NewClient() and call its RateQuote() method directly.
+func (r *RateQuoteRequest) RateQuote() (responseData RateQuoteResponse, err error) {
+	c := NewClient(r.ID)
+	c.TestMode = testMode
+	c.SetTimeout(timeout / time.Second)
+	return c.RateQuote(r)
+}
@@ -0,0 +1,180 @@
+package abf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+//Transport sends an HTTP request and returns its response. It's the same shape
+//as http.RoundTripper, exposed as our own interface so Policies can wrap
+//anything that implements it, not just an http.Client's transport.
+type Transport interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+//Policy wraps a Transport with additional behavior (logging, retry, metrics, ...)
+//and returns a Transport that runs that behavior around the next one in the
+//chain. A Client applies its Policies in order, so Policies[0] is outermost.
+type Policy func(next Transport) Transport
+
+//transportFunc adapts a plain function, like http.Client.Do, to the Transport
+//interface
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+//buildTransport wraps base with policies in order, so policies[0] runs first
+//and closest to the caller.
+func buildTransport(base Transport, policies []Policy) Transport {
+	t := base
+	for i := len(policies) - 1; i >= 0; i-- {
+		t = policies[i](t)
+	}
+	return t
+}
+
+//LogLevel controls how much request/response detail LoggingPolicy writes
+type LogLevel int
+
+//log levels for LoggingPolicy
+const (
+	LogLevelNone LogLevel = iota
+	LogLevelError
+	LogLevelDebug
+)
+
+//LoggingPolicy logs requests and responses at LogLevelDebug and only transport
+//errors at LogLevelError. This replaces the old unconditional
+//log.Println(v.Encode()) that wrote every request, API key included, to stdout
+//no matter what the caller wanted.
+func LoggingPolicy(level LogLevel) Policy {
+	return func(next Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			if level >= LogLevelDebug {
+				log.Printf("abf: request %s %s", req.Method, req.URL)
+			}
+
+			res, err := next.Do(req)
+
+			switch {
+			case err != nil && level >= LogLevelError:
+				log.Printf("abf: request error: %v", err)
+			case res != nil && level >= LogLevelDebug:
+				log.Printf("abf: response status %s", res.Status)
+			}
+
+			return res, err
+		})
+	}
+}
+
+//RequestIDPolicy sets an X-Request-ID header on every outgoing request using
+//newID, so requests can be correlated across logs, metrics, and ABF support
+//tickets.
+func RequestIDPolicy(newID func() string) Policy {
+	return func(next Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Request-ID", newID())
+			return next.Do(req)
+		})
+	}
+}
+
+//RetryPolicy retries a request when the response status is 5xx, backing off
+//with the same jittered exponential schedule DeliveryQueue uses. It relies on
+//req.GetBody to replay the request body on each attempt; http.NewRequest sets
+//this automatically for the common body types (*bytes.Buffer, *bytes.Reader,
+//*strings.Reader).
+func RetryPolicy(backoff BackoffConfig) Policy {
+	return func(next Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			interval := backoff.InitialInterval
+			var res *http.Response
+			var err error
+
+			for attempt := 0; ; attempt++ {
+				if attempt > 0 && req.GetBody != nil {
+					body, bodyErr := req.GetBody()
+					if bodyErr != nil {
+						return res, bodyErr
+					}
+					req.Body = body
+				}
+
+				res, err = next.Do(req)
+				if err != nil || res.StatusCode < http.StatusInternalServerError {
+					return res, err
+				}
+
+				if attempt >= backoff.MaxRetries {
+					return res, err
+				}
+
+				res.Body.Close()
+				time.Sleep(jitter(interval))
+
+				interval *= 2
+				if interval > backoff.MaxInterval {
+					interval = backoff.MaxInterval
+				}
+			}
+		})
+	}
+}
+
+//CaptureResponsePolicy calls sink with a copy of every response body, then
+//restores the body so the rest of the chain (and the caller) can still read it.
+//Useful for logging raw ABF XML while debugging without changing
+//RequestPickup/RateQuote.
+func CaptureResponsePolicy(sink func(req *http.Request, body []byte)) Policy {
+	return func(next Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			res, err := next.Do(req)
+			if err != nil || res == nil {
+				return res, err
+			}
+
+			body, readErr := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if readErr != nil {
+				return res, readErr
+			}
+
+			sink(req, body)
+			res.Body = ioutil.NopCloser(bytes.NewReader(body))
+			return res, nil
+		})
+	}
+}
+
+//MetricsRecorder records the outcome of one request/response round trip.
+//Implement this backed by a Prometheus counter/histogram (or anything else) and
+//pass it to MetricsPolicy; this package doesn't take a direct Prometheus
+//dependency of its own.
+type MetricsRecorder interface {
+	Observe(statusCode int, duration time.Duration, err error)
+}
+
+//MetricsPolicy reports the status code, duration, and error of every request to
+//recorder.
+func MetricsPolicy(recorder MetricsRecorder) Policy {
+	return func(next Transport) Transport {
+		return transportFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			res, err := next.Do(req)
+
+			statusCode := 0
+			if res != nil {
+				statusCode = res.StatusCode
+			}
+			recorder.Observe(statusCode, time.Since(start), err)
+
+			return res, err
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package abf
+
+import (
+	stderrors "errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+//zipRE matches a 5 digit US zip code
+var zipRE = regexp.MustCompile(`^\d{5}$`)
+
+//hhmmRE matches a 24 hour hh:mm time, ex: "08:00" or "17:30"
+var hhmmRE = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+//ValidClasses is the list of NMFC freight classes ABF accepts on a Commodity
+var ValidClasses = []string{
+	"50", "55", "60", "65", "70", "77.5", "85", "92.5", "100", "110",
+	"125", "150", "175", "200", "250", "300", "400", "500",
+}
+
+//Validate checks that p has every field ABF requires and that the formatted
+//fields (zips, times, dates) match what ABF expects, so an obviously bad request
+//fails locally instead of round-tripping to ABF first. It returns every problem
+//found, joined with errors.Join, rather than stopping at the first one.
+func (p *PickupRequest) Validate() error {
+	var errs []error
+
+	if p.ID == "" {
+		errs = append(errs, stderrors.New("ID is required"))
+	}
+	switch p.RequesterType {
+	case RequesterShipper, RequesterConsignee, RequesterThirdParty:
+	default:
+		errs = append(errs, fmt.Errorf("RequesterType %q is not one of the defined constants", p.RequesterType))
+	}
+	switch p.PayTerms {
+	case PayTermsPrepaid, PayTermsCollect:
+	default:
+		errs = append(errs, fmt.Errorf("PayTerms %q is not \"P\" or \"C\"", p.PayTerms))
+	}
+
+	if p.ShipContact == "" {
+		errs = append(errs, stderrors.New("ShipContact is required"))
+	}
+	if p.ShipName == "" {
+		errs = append(errs, stderrors.New("ShipName is required"))
+	}
+	if p.ShipAddress == "" {
+		errs = append(errs, stderrors.New("ShipAddress is required"))
+	}
+	if p.ShipCity == "" {
+		errs = append(errs, stderrors.New("ShipCity is required"))
+	}
+	if p.ShipState == "" {
+		errs = append(errs, stderrors.New("ShipState is required"))
+	}
+	if !zipRE.MatchString(p.ShipZip) {
+		errs = append(errs, fmt.Errorf("ShipZip %q is not a 5 digit zip", p.ShipZip))
+	}
+	if p.ShipCountry == "" {
+		errs = append(errs, stderrors.New("ShipCountry is required"))
+	}
+	if p.ShipPhone == "" {
+		errs = append(errs, stderrors.New("ShipPhone is required"))
+	}
+
+	if p.ConsCity == "" {
+		errs = append(errs, stderrors.New("ConsCity is required"))
+	}
+	if p.ConsState == "" {
+		errs = append(errs, stderrors.New("ConsState is required"))
+	}
+	if !zipRE.MatchString(p.ConsZip) {
+		errs = append(errs, fmt.Errorf("ConsZip %q is not a 5 digit zip", p.ConsZip))
+	}
+	if p.ConsCountry == "" {
+		errs = append(errs, stderrors.New("ConsCountry is required"))
+	}
+
+	if _, err := time.Parse("01/02/2006", p.PickupDate); err != nil {
+		errs = append(errs, fmt.Errorf("PickupDate %q is not mm/dd/yyyy", p.PickupDate))
+	}
+	if !hhmmRE.MatchString(p.AT) {
+		errs = append(errs, fmt.Errorf("AT %q is not hh:mm", p.AT))
+	}
+	if !hhmmRE.MatchString(p.OT) {
+		errs = append(errs, fmt.Errorf("OT %q is not hh:mm", p.OT))
+	}
+	if !hhmmRE.MatchString(p.CT) {
+		errs = append(errs, fmt.Errorf("CT %q is not hh:mm", p.CT))
+	}
+
+	if len(p.Items) == 0 {
+		errs = append(errs, stderrors.New("at least one Commodity is required in Items"))
+	}
+	if len(p.Items) > 15 {
+		errs = append(errs, fmt.Errorf("%d Items given, ABF allows at most 15", len(p.Items)))
+	}
+	for i, item := range p.Items {
+		if err := item.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("Items[%d]: %w", i, err))
+		}
+	}
+
+	return stderrors.Join(errs...)
+}
+
+//Validate checks that c has the fields ABF requires for a single commodity line.
+//It returns every problem found, joined with errors.Join, rather than stopping
+//at the first one.
+func (c *Commodity) Validate() error {
+	var errs []error
+
+	if c.HandlingUnits > 0 && c.Weight <= 0 {
+		errs = append(errs, stderrors.New("Weight must be greater than 0 when HandlingUnits is set"))
+	}
+	if c.Class != "" && !isValidClass(c.Class) {
+		errs = append(errs, fmt.Errorf("Class %q is not a recognized NMFC class", c.Class))
+	}
+	if c.Hazmat != "" && c.Hazmat != "Y" && c.Hazmat != "N" {
+		errs = append(errs, fmt.Errorf("Hazmat %q must be \"Y\" or \"N\"", c.Hazmat))
+	}
+
+	return stderrors.Join(errs...)
+}
+
+//isValidClass reports whether class is one of the NMFC classes ABF accepts
+func isValidClass(class string) bool {
+	for _, c := range ValidClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}